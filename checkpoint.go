@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CheckpointRecord is what gets persisted after every successful flush, so
+// a restarted pg_kinesis can resume from exactly where it left off instead
+// of relying solely on what Postgres has confirmed for the slot (which can
+// lag or, across a crash between "acked to the sink" and "acked to
+// Postgres", disagree with what the sink actually has).
+type CheckpointRecord struct {
+	Slot          string    `json:"slot"`
+	StreamShard   string    `json:"streamShard"`
+	LastAckedLSN  uint64    `json:"lastAckedLsn"`
+	LastFlushTime time.Time `json:"lastFlushTime"`
+}
+
+// CheckpointStore persists and retrieves the single CheckpointRecord for a
+// slot. Save must be atomic with respect to concurrent readers: a reader
+// should never observe a partially-written record.
+type CheckpointStore interface {
+	Load(slot string) (*CheckpointRecord, error) // nil, nil if no checkpoint exists yet
+	Save(rec CheckpointRecord) error
+	Reset(slot string) error
+}
+
+// newCheckpointStore builds the CheckpointStore selected by --checkpoint,
+// e.g. file:///var/lib/pg_kinesis/checkpoint.json, dynamodb://table-name,
+// or redis://host:6379/0.
+func newCheckpointStore(uri string) (CheckpointStore, error) {
+	if uri == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse --checkpoint %q", uri)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return newFileCheckpointStore(u.Path), nil
+	case "dynamodb":
+		return newDynamoDBCheckpointStore(u.Host), nil
+	case "redis":
+		return newRedisCheckpointStore(uri)
+	default:
+		return nil, errors.Errorf("unknown --checkpoint scheme %q; must be one of file, dynamodb, redis", u.Scheme)
+	}
+}
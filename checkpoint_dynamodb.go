@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"github.com/pkg/errors"
+)
+
+// dynamoDBCheckpointStore keeps the checkpoint record as a single item
+// (keyed by slot) in a DynamoDB table, for operators who'd rather not
+// depend on a shared filesystem across HA hosts. PutItem/GetItem are each
+// atomic at the item level, which is all Save/Load need.
+type dynamoDBCheckpointStore struct {
+	table  string
+	client *dynamodb.DynamoDB
+}
+
+func newDynamoDBCheckpointStore(table string) *dynamoDBCheckpointStore {
+	return &dynamoDBCheckpointStore{
+		table:  table,
+		client: dynamodb.New(session.New(aws.NewConfig())),
+	}
+}
+
+func (s *dynamoDBCheckpointStore) Load(slot string) (*CheckpointRecord, error) {
+	out, err := s.client.GetItem(&dynamodb.GetItemInput{
+		TableName: &s.table,
+		Key: map[string]*dynamodb.AttributeValue{
+			"slot": {S: &slot},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load checkpoint from DynamoDB table %s", s.table)
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var rec CheckpointRecord
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &rec); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode checkpoint from DynamoDB table %s", s.table)
+	}
+
+	return &rec, nil
+}
+
+func (s *dynamoDBCheckpointStore) Save(rec CheckpointRecord) error {
+	item, err := dynamodbattribute.MarshalMap(rec)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode checkpoint for DynamoDB")
+	}
+
+	_, err = s.client.PutItem(&dynamodb.PutItemInput{
+		TableName: &s.table,
+		Item:      item,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to save checkpoint to DynamoDB table %s", s.table)
+	}
+
+	return nil
+}
+
+func (s *dynamoDBCheckpointStore) Reset(slot string) error {
+	_, err := s.client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: &s.table,
+		Key: map[string]*dynamodb.AttributeValue{
+			"slot": {S: &slot},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to reset checkpoint in DynamoDB table %s", s.table)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// fileCheckpointStore keeps a single JSON record on local disk. Save writes
+// to a temp file in the same directory and renames it into place, so a
+// reader (or a crash mid-write) never sees a half-written checkpoint.
+type fileCheckpointStore struct {
+	path string
+}
+
+func newFileCheckpointStore(path string) *fileCheckpointStore {
+	return &fileCheckpointStore{path: path}
+}
+
+func (s *fileCheckpointStore) Load(slot string) (*CheckpointRecord, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "unable to read checkpoint file %s", s.path)
+	}
+
+	var rec CheckpointRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse checkpoint file %s", s.path)
+	}
+
+	if rec.Slot != slot {
+		return nil, nil
+	}
+
+	return &rec, nil
+}
+
+func (s *fileCheckpointStore) Save(rec CheckpointRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize checkpoint")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "unable to create temp file for checkpoint %s", s.path)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "unable to write checkpoint %s", s.path)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "unable to close temp checkpoint file %s", s.path)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return errors.Wrapf(err, "unable to rename temp checkpoint file into place at %s", s.path)
+	}
+
+	return nil
+}
+
+func (s *fileCheckpointStore) Reset(slot string) error {
+	err := os.Remove(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// ColumnValue is a single typed value lifted out of a logical decoding
+// message. TypeOID is only populated where the source's type OID is known
+// (pgoutput, and --initial-snapshot rows); test_decoding only ever gives us
+// the type name.
+type ColumnValue struct {
+	Value   interface{} `json:"v"`
+	Type    string      `json:"t"`
+	TypeOID uint32      `json:"typeoid,omitempty"`
+}
+
+// ColumnChange is the old/new pair for a single column in a row change.
+// DELETEs only populate Old, INSERTs only populate New, and UPDATEs
+// populate both (Old is omitted if REPLICA IDENTITY doesn't give us the
+// previous value; test_decoding also omits Old for columns whose value did
+// not change).
+type ColumnChange struct {
+	Old *ColumnValue `json:"old,omitempty"`
+	New *ColumnValue `json:"new,omitempty"`
+}
+
+// ChangeEvent is the common representation that every Decoder normalizes
+// its plugin-specific wire format into. handleReplicationMsg and
+// marshalChangeEventToJSON only ever deal with this type, so adding a new
+// logical decoding plugin means implementing Decoder and nothing else.
+type ChangeEvent struct {
+	LSN       uint64
+	Xid       uint32
+	Table     string
+	Operation string // INSERT, UPDATE, DELETE, or TRUNCATE
+	Columns   map[string]ColumnChange
+
+	// Key is the row's primary/replica-identity key serialized as a
+	// stable string, used to partition sinks that want per-row ordering
+	// (e.g. Kafka). Empty when the decoder can't determine one, in which
+	// case callers should fall back to partitioning by Table.
+	Key string
+}
+
+// Decoder turns the raw WAL data of a single replication message into zero
+// or more ChangeEvents. Zero events means the message was consumed (e.g. a
+// BEGIN/COMMIT or a relation cache update) and should not be streamed; more
+// than one happens for a single TRUNCATE spanning several tables.
+type Decoder interface {
+	Name() string
+	Decode(msg *pgx.ReplicationMessage) ([]*ChangeEvent, error)
+}
+
+// newDecoder builds the Decoder selected by --plugin.
+func newDecoder(plugin string) (Decoder, error) {
+	switch plugin {
+	case "test_decoding":
+		return &testDecodingDecoder{}, nil
+	case "pgoutput":
+		return newPgoutputDecoder(), nil
+	default:
+		return nil, errors.Errorf("unknown --plugin %q; must be one of test_decoding, pgoutput", plugin)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"github.com/nickelser/parselogical"
+	"github.com/pkg/errors"
+)
+
+// testDecodingDecoder decodes the output of Postgres' built-in test_decoding
+// plugin. It only ever sees text values, so typed values and TypeOID are
+// unavailable; everything comes back as a string with its pg_catalog type
+// name attached.
+type testDecodingDecoder struct{}
+
+func (d *testDecodingDecoder) Name() string { return "test_decoding" }
+
+func (d *testDecodingDecoder) Decode(msg *pgx.ReplicationMessage) ([]*ChangeEvent, error) {
+	walString := string(msg.WalMessage.WalData)
+	pr := parselogical.NewParseResult(walString)
+
+	if err := pr.ParsePrelude(); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse table or operation type of replication message: %s", walString)
+	}
+
+	// Unlike pgoutputDecoder, we discard the BEGIN line instead of parsing
+	// its xid out of it, so ChangeEvent.Xid is always 0 for test_decoding
+	// output — the pgoutput plugin is the only one that populates it.
+	if pr.Operation == "BEGIN" || pr.Operation == "COMMIT" {
+		return nil, nil
+	}
+
+	if err := pr.ParseColumns(); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse columns of the replication message: %s", walString)
+	}
+
+	columns := make(map[string]ColumnChange, len(pr.Columns))
+
+	for k, v := range pr.Columns {
+		oldV, ok := pr.OldColumns[k]
+
+		if pr.Operation == "DELETE" {
+			columns[k] = ColumnChange{Old: testDecodingColumnValue(&v)}
+		} else if ok && v.Value != oldV.Value {
+			columns[k] = ColumnChange{New: testDecodingColumnValue(&v), Old: testDecodingColumnValue(&oldV)}
+		} else {
+			columns[k] = ColumnChange{New: testDecodingColumnValue(&v)}
+		}
+	}
+
+	return []*ChangeEvent{{
+		LSN:       msg.WalMessage.WalStart,
+		Table:     pr.Relation,
+		Operation: pr.Operation,
+		Columns:   columns,
+	}}, nil
+}
+
+func testDecodingColumnValue(cv *parselogical.ColumnValue) *ColumnValue {
+	return &ColumnValue{Value: cv.Value, Type: cv.Type}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logger and sugar back logf/logerrf/logerror (see pg_kinesis.go), and are
+// available directly to call sites that want structured fields (slot,
+// stream, table, lsn, op) alongside the message. Both default to a no-op
+// logger until initLogger runs, so package-level init ordering never panics.
+var logger = zap.NewNop()
+var sugar = logger.Sugar()
+
+// perTableSampleFirst/perTableSampleThereafter bound how many identical INFO
+// log lines are emitted per second before zap starts dropping the rest.
+// zap's sampler keys on (level, message), so this is only a per-table quota
+// for call sites (like the "change event" log) that fold the table name
+// into the message text; a bulk-loaded table can't drown out quieter ones
+// in the same tick because each table samples under its own message key.
+const (
+	perTableSampleTick       = time.Second
+	perTableSampleFirst      = 100
+	perTableSampleThereafter = 100
+)
+
+// initLogger builds the package-level logger/sugar from --log-format: json
+// (the default, for Loki/ELK ingestion) or console (for local development).
+func initLogger(format string) error {
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.TimeKey = "ts"
+	encoderCfg.EncodeTime = zapcore.RFC3339TimeEncoder
+
+	var encoder zapcore.Encoder
+	switch format {
+	case "", "json":
+		encoder = zapcore.NewJSONEncoder(encoderCfg)
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(encoderCfg)
+	default:
+		return errors.Errorf("unknown --log-format %q; must be one of json, console", format)
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), zapcore.InfoLevel)
+	core = zapcore.NewSamplerWithOptions(core, perTableSampleTick, perTableSampleFirst, perTableSampleThereafter)
+
+	logger = zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
+	sugar = logger.Sugar()
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/jackc/pgx"
+)
+
+// ReplicationLagPollInterval is how often the replication_lag_bytes gauge
+// is refreshed from a side connection to the source DB.
+const ReplicationLagPollInterval = 10 * time.Second
+
+// postgresEpochOffset is the number of seconds between the Unix epoch and
+// the Postgres epoch (2000-01-01), which WalMessage.ServerTime is relative to.
+const postgresEpochOffset = 946684800
+
+var (
+	metricChangeEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pg_kinesis_change_events_total",
+		Help: "Change events seen, by source table and operation (insert, update, delete, truncate, skipped).",
+	}, []string{"table", "operation"})
+
+	metricPutRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pg_kinesis_put_records_total",
+		Help: "Records successfully handed off by the sink.",
+	}, []string{"sink"})
+
+	metricPutRecordsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pg_kinesis_put_records_failed_total",
+		Help: "Records that failed a sink flush attempt (Kinesis retries them; a Kafka failure fails the flush).",
+	}, []string{"sink"})
+
+	metricKinesisPutRecordsDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kinesis_put_records_duration_seconds",
+		Help:    "Duration of Kinesis PutRecords calls.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	metricWalToAckLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wal_to_ack_latency_seconds",
+		Help:    "Time from a WAL message's server timestamp to the sink acking it.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	metricReplicationLagBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "replication_lag_bytes",
+		Help: "Bytes between pg_current_wal_lsn() on the source and the last LSN sent to Postgres in a standby status update.",
+	})
+
+	metricRecordsPendingFlush = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "records_pending_flush",
+		Help: "Records enqueued in the sink but not yet flushed/acked.",
+	})
+
+	metricHeartbeatLagBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heartbeat_lag_bytes",
+		Help: "Bytes between the server's WAL end (from the last replication heartbeat) and the last LSN sent in a standby status update. Climbs on an idle slot unless --idle-lsn-advance is set.",
+	})
+)
+
+// startMetricsServer serves the Prometheus /metrics endpoint in the
+// background; errors (e.g. the address is already in use) are logged but
+// don't take down replication.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logerror(errors.Wrapf(err, "metrics server on %s failed", addr))
+		}
+	}()
+}
+
+// pollReplicationLag refreshes replication_lag_bytes off a dedicated
+// connection (separate from the replication connection, which can't run
+// ordinary queries) until shutdown.
+func pollReplicationLag(sourceConfig pgx.ConnConfig) {
+	conn, err := pgx.Connect(sourceConfig)
+	if err != nil {
+		logerror(errors.Wrap(err, "unable to open side connection for replication lag polling"))
+		return
+	}
+	defer conn.Close()
+
+	for !done.IsSet() {
+		var currentLSN string
+		if err := conn.QueryRow("SELECT pg_current_wal_lsn()").Scan(&currentLSN); err != nil {
+			logerror(errors.Wrap(err, "unable to poll pg_current_wal_lsn for replication lag"))
+		} else if lsn, err := pgx.ParseLSN(currentLSN); err == nil {
+			metricReplicationLagBytes.Set(float64(lsn - sentWal()))
+		}
+
+		time.Sleep(ReplicationLagPollInterval)
+	}
+}
+
+// walServerTime converts a WalMessage's ServerTime (microseconds since the
+// Postgres epoch) into a time.Time, or the zero Time if msg carries none.
+func walServerTime(msg *pgx.ReplicationMessage) time.Time {
+	if msg == nil || msg.WalMessage == nil || msg.WalMessage.ServerTime == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(postgresEpochOffset, 0).Add(time.Duration(msg.WalMessage.ServerTime) * time.Microsecond)
+}
+
+// observeWalToAckLatency records wal_to_ack_latency_seconds for the message
+// that triggered a flush, if it carries a server timestamp.
+func observeWalToAckLatency(msg *pgx.ReplicationMessage) {
+	t := walServerTime(msg)
+	if t.IsZero() {
+		return
+	}
+
+	metricWalToAckLatency.Observe(time.Since(t).Seconds())
+}
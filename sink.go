@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Sink is the destination pg_kinesis streams change events to. Put enqueues
+// a single record; implementations are free to batch internally, but must
+// not report a record as acknowledged from Flush until it has actually been
+// durably accepted by the destination. flushed is true when Put's enqueuing
+// caused an implicit flush (e.g. a batch size limit was hit), mirroring the
+// original Kinesis-only putRecord/flushRecords split so the replication
+// loop's ack-on-flush behavior is unchanged.
+type Sink interface {
+	// Put enqueues payload, keyed by partitionKey, noting that it was
+	// produced from the given source LSN. tableSchema identifies which
+	// source table the record came from, for sinks that route by table
+	// (e.g. Kafka topics).
+	Put(ctx context.Context, tableSchema string, partitionKey string, payload []byte, lsn uint64) (flushed bool, err error)
+
+	// Flush blocks until all enqueued records have been durably accepted,
+	// returning the highest LSN among them.
+	Flush(ctx context.Context) (ackedLSN uint64, err error)
+
+	Close() error
+}
+
+// sinkConfig carries every --sink=* flag through to whichever Sink
+// newSink constructs; irrelevant fields for a given sink kind are ignored.
+type sinkConfig struct {
+	stream string // kinesis
+
+	brokers       []string // kafka
+	topicTemplate string
+	acks          string
+	compression   string
+}
+
+func newSink(kind string, cfg sinkConfig) (Sink, error) {
+	switch kind {
+	case "kinesis":
+		return newKinesisSink(cfg.stream), nil
+	case "kafka":
+		return newKafkaSink(cfg)
+	default:
+		return nil, errors.Errorf("unknown --sink %q; must be one of kinesis, kafka", kind)
+	}
+}
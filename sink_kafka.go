@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// kafkaSink batches records the same way kinesisSink does (up to
+// maxRecordsPerRequest between flushes) and hands them to Kafka via a
+// SyncProducer, so Flush only returns once the broker(s) have acked
+// according to --acks.
+type kafkaSink struct {
+	producer      sarama.SyncProducer
+	topicTemplate string
+
+	messages []*sarama.ProducerMessage
+	lsns     []uint64
+}
+
+func newKafkaSink(cfg sinkConfig) (*kafkaSink, error) {
+	if len(cfg.brokers) == 0 {
+		return nil, errors.New("--sink=kafka requires --brokers")
+	}
+
+	if cfg.topicTemplate == "" {
+		return nil, errors.New("--sink=kafka requires --topic-template")
+	}
+
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+
+	acks, err := kafkaRequiredAcks(cfg.acks)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.RequiredAcks = acks
+
+	compression, err := kafkaCompressionCodec(cfg.compression)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Compression = compression
+
+	producer, err := sarama.NewSyncProducer(cfg.brokers, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to Kafka brokers %v", cfg.brokers)
+	}
+
+	return &kafkaSink{producer: producer, topicTemplate: cfg.topicTemplate}, nil
+}
+
+func (s *kafkaSink) Put(ctx context.Context, tableSchema string, partitionKey string, payload []byte, lsn uint64) (bool, error) {
+	if len(payload) > maxRecordSize {
+		return false, errors.New("replication messages must be less than 1MB in size")
+	}
+
+	s.messages = append(s.messages, &sarama.ProducerMessage{
+		Topic: kafkaTopicForTable(s.topicTemplate, tableSchema),
+		Key:   sarama.StringEncoder(partitionKey),
+		Value: sarama.ByteEncoder(payload),
+	})
+	s.lsns = append(s.lsns, lsn)
+
+	if len(s.messages) < maxRecordsPerRequest {
+		return false, nil
+	}
+
+	_, err := s.Flush(ctx)
+	return err == nil, err
+}
+
+func (s *kafkaSink) Flush(ctx context.Context) (uint64, error) {
+	if len(s.messages) == 0 {
+		return 0, nil
+	}
+
+	ackedLSN := s.lsns[len(s.lsns)-1]
+
+	if err := s.producer.SendMessages(s.messages); err != nil {
+		metricPutRecordsFailed.WithLabelValues("kafka").Add(float64(len(s.messages)))
+		return 0, errors.Wrap(err, "kafka SendMessages failed")
+	}
+
+	atomic.AddUint64(&stats.putRecords, uint64(len(s.messages)))
+	metricPutRecords.WithLabelValues("kafka").Add(float64(len(s.messages)))
+	s.messages = nil
+	s.lsns = nil
+
+	return ackedLSN, nil
+}
+
+func (s *kafkaSink) Close() error {
+	return s.producer.Close()
+}
+
+// kafkaTopicForTable expands a --topic-template like "{schema}.{table}"
+// against a "schema.table"-formatted relation name.
+func kafkaTopicForTable(template string, tableSchema string) string {
+	schema, table := tableSchema, ""
+
+	if idx := strings.Index(tableSchema, "."); idx >= 0 {
+		schema, table = tableSchema[:idx], tableSchema[idx+1:]
+	}
+
+	topic := strings.Replace(template, "{schema}", schema, -1)
+	topic = strings.Replace(topic, "{table}", table, -1)
+	return topic
+}
+
+func kafkaRequiredAcks(acks string) (sarama.RequiredAcks, error) {
+	switch acks {
+	case "", "all":
+		return sarama.WaitForAll, nil
+	case "leader":
+		return sarama.WaitForLocal, nil
+	case "none":
+		return sarama.NoResponse, nil
+	default:
+		return 0, errors.Errorf("unknown --acks %q; must be one of none, leader, all", acks)
+	}
+}
+
+func kafkaCompressionCodec(compression string) (sarama.CompressionCodec, error) {
+	switch compression {
+	case "", "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	default:
+		return 0, errors.Errorf("unknown --compression %q; must be one of none, gzip, snappy, lz4, zstd", compression)
+	}
+}
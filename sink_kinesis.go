@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+
+	"github.com/jpillora/backoff"
+	"github.com/pkg/errors"
+)
+
+// kinesisSink batches records into Kinesis PutRecords calls, the same way
+// pg_kinesis always has: up to maxRecordsPerRequest records are buffered
+// between flushes, and a failed PutRecords is retried with backoff,
+// resubmitting only the records Kinesis reported as failed.
+type kinesisSink struct {
+	stream  string
+	client  *kinesis.Kinesis
+	records []*kinesis.PutRecordsRequestEntry
+	lsns    []uint64
+}
+
+func newKinesisSink(stream string) *kinesisSink {
+	return &kinesisSink{
+		stream: stream,
+		client: kinesis.New(session.New(aws.NewConfig())),
+	}
+}
+
+func (s *kinesisSink) Put(ctx context.Context, tableSchema string, partitionKey string, payload []byte, lsn uint64) (bool, error) {
+	if len(payload) > maxRecordSize {
+		return false, errors.New("replication messages must be less than 1MB in size")
+	}
+
+	s.records = append(s.records, &kinesis.PutRecordsRequestEntry{
+		Data:         payload,
+		PartitionKey: &partitionKey,
+	})
+	s.lsns = append(s.lsns, lsn)
+
+	if len(s.records) < maxRecordsPerRequest {
+		return false, nil
+	}
+
+	_, err := s.Flush(ctx)
+	return err == nil, err
+}
+
+func (s *kinesisSink) Flush(ctx context.Context) (uint64, error) {
+	if len(s.records) == 0 {
+		return 0, nil
+	}
+
+	ackedLSN := s.lsns[len(s.lsns)-1]
+
+	b := &backoff.Backoff{
+		Jitter: true,
+	}
+
+	for b.Attempt() < 100 && !done.IsSet() {
+		retryDuration := b.Duration()
+
+		startTime := time.Now()
+		out, err := s.client.PutRecords(&kinesis.PutRecordsInput{
+			StreamName: &s.stream,
+			Records:    s.records,
+		})
+		elapsed := time.Since(startTime)
+		metricKinesisPutRecordsDuration.Observe(elapsed.Seconds())
+
+		if err != nil {
+			logerror(errors.Wrapf(err, "kinesis PutRecords failed; retrying failed records in %s", retryDuration.String()))
+			metricPutRecordsFailed.WithLabelValues("kinesis").Add(float64(len(s.records)))
+			s.client = kinesis.New(session.New(aws.NewConfig())) // refresh the client to get new credentials etc.
+			time.Sleep(retryDuration)
+		} else if *out.FailedRecordCount > 0 {
+			logerrf("%d records failed during Kinesis PutRecords; retrying in %s", *out.FailedRecordCount, retryDuration.String())
+			originalRecordsCount := uint64(len(s.records))
+			atomic.AddUint64(&stats.putRecordsTime, uint64(elapsed))
+			s.records, s.lsns = kinesisFailures(s.records, s.lsns, out.Records)
+			sentCount := originalRecordsCount - uint64(len(s.records)) // total - unsent = sent
+			atomic.AddUint64(&stats.putRecords, sentCount)
+			metricPutRecords.WithLabelValues("kinesis").Add(float64(sentCount))
+			metricPutRecordsFailed.WithLabelValues("kinesis").Add(float64(*out.FailedRecordCount))
+			time.Sleep(retryDuration)
+		} else if *out.FailedRecordCount == 0 {
+			atomic.AddUint64(&stats.putRecordsTime, uint64(elapsed))
+			atomic.AddUint64(&stats.putRecords, uint64(len(s.records)))
+			metricPutRecords.WithLabelValues("kinesis").Add(float64(len(s.records)))
+			s.records = nil
+			s.lsns = nil
+			return ackedLSN, nil
+		}
+
+		if done.IsSet() {
+			return 0, errors.New("interrupted PutRecords due to shutdown")
+		}
+	}
+
+	return 0, errors.New("failed to put records after many attempts")
+}
+
+func (s *kinesisSink) Close() error {
+	return nil
+}
+
+func kinesisFailures(records []*kinesis.PutRecordsRequestEntry, lsns []uint64,
+	response []*kinesis.PutRecordsResultEntry) (outRecords []*kinesis.PutRecordsRequestEntry, outLsns []uint64) {
+	for i, record := range response {
+		if record.ErrorCode != nil {
+			outRecords = append(outRecords, records[i])
+			outLsns = append(outLsns, lsns[i])
+		}
+	}
+	return outRecords, outLsns
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// DefaultSnapshotChunkSize is the number of rows fetched per keyset page
+// when copying a table that has a usable single-column primary key.
+const DefaultSnapshotChunkSize = 10000
+
+// snapshotTableState is the on-disk, per-table progress record for an
+// --initial-snapshot run. LastKey is the last primary key value copied, so
+// a restart can resume a large table mid-copy instead of redoing it.
+type snapshotTableState struct {
+	Done    bool   `json:"done"`
+	LastKey string `json:"lastKey,omitempty"`
+}
+
+// snapshotState is the small JSON state file an --initial-snapshot run
+// checkpoints into after every table (and, for keyset-paginated tables,
+// after every chunk), so an interrupted snapshot can resume instead of
+// starting over.
+type snapshotState struct {
+	path string
+
+	mu     sync.Mutex
+	Tables map[string]*snapshotTableState `json:"tables"`
+}
+
+func loadSnapshotState(path string) (*snapshotState, error) {
+	s := &snapshotState{path: path, Tables: make(map[string]*snapshotTableState)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "unable to read snapshot state file %s", path)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse snapshot state file %s", path)
+	}
+
+	return s, nil
+}
+
+func (s *snapshotState) save(table string, state snapshotTableState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Tables[table] = &state
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize snapshot state")
+	}
+
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+func (s *snapshotState) get(table string) snapshotTableState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.Tables[table]; ok {
+		return *st
+	}
+
+	return snapshotTableState{}
+}
+
+// runInitialSnapshot creates slot with an exported snapshot, copies every
+// table matched by -t/-T into sink as synthetic INSERT ChangeEvents tagged
+// with the snapshot's consistent LSN, then returns that LSN so the caller
+// can start logical replication immediately after it. It is meant to run
+// once, before the first connectReplicateLoop call, on --create
+// --initial-snapshot.
+func runInitialSnapshot(slot *string, sourceConfig pgx.ConnConfig, plugin *string, sinkKind *string, sinkCfg sinkConfig, workers int, statePath string) (uint64, error) {
+	exportConn, consistentPoint, snapshotName, err := createReplicationSlotWithSnapshot(slot, sourceConfig, *plugin)
+	if err != nil {
+		return 0, err
+	}
+	// The exported snapshot only lives as long as this connection does, so
+	// it must stay open (idle) until every snapshotTable worker below has
+	// finished importing it.
+	defer exportConn.Close()
+
+	logf("created replication slot %s with exported snapshot %s at LSN %s", *slot, snapshotName, consistentPoint)
+
+	catalogConn, err := pgx.Connect(sourceConfig)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to connect to source DB to enumerate tables for snapshot")
+	}
+	defer catalogConn.Close()
+
+	tableNames, err := listSnapshotTables(catalogConn)
+	if err != nil {
+		return 0, err
+	}
+
+	state, err := loadSnapshotState(statePath)
+	if err != nil {
+		return 0, err
+	}
+
+	lsn, err := pgx.ParseLSN(consistentPoint)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to parse snapshot consistent_point %s", consistentPoint)
+	}
+
+	jobs := make(chan string)
+	results := make(chan error, len(tableNames))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for table := range jobs {
+				results <- snapshotTable(sourceConfig, snapshotName, table, lsn, sinkKind, sinkCfg, state)
+			}
+		}()
+	}
+
+	go func() {
+		for _, table := range tableNames {
+			if state.get(table).Done {
+				logf("snapshot: skipping already-completed table %s", table)
+				continue
+			}
+			jobs <- table
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for err := range results {
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	logf("initial snapshot complete, resuming replication from LSN %s", consistentPoint)
+
+	return lsn, nil
+}
+
+// snapshotTable copies a single table under the exported snapshot, using
+// keyset pagination over its primary key when it has a single-column one
+// (so very large tables can checkpoint progress and resume), falling back
+// to a single unpaginated read otherwise.
+func snapshotTable(sourceConfig pgx.ConnConfig, snapshotName string, table string, lsn uint64, sinkKind *string, sinkCfg sinkConfig, state *snapshotState) error {
+	conn, err := pgx.Connect(sourceConfig)
+	if err != nil {
+		return errors.Wrapf(err, "unable to connect to source DB to snapshot table %s", table)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec("BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+		return errors.Wrapf(err, "unable to begin snapshot transaction for table %s", table)
+	}
+	defer conn.Exec("COMMIT")
+
+	if _, err := conn.Exec(fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", snapshotName)); err != nil {
+		return errors.Wrapf(err, "unable to set transaction snapshot for table %s", table)
+	}
+
+	sink, err := newSink(*sinkKind, sinkCfg)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	pkColumn, err := primaryKeyColumn(conn, table)
+	if err != nil {
+		return err
+	}
+
+	lastKey := state.get(table).LastKey
+	rowsCopied := 0
+
+	for {
+		sql, args := snapshotSelect(table, pkColumn, lastKey)
+
+		rows, err := conn.Query(sql, args...)
+		if err != nil {
+			return errors.Wrapf(err, "unable to query rows for snapshot of table %s", table)
+		}
+
+		fields := rows.FieldDescriptions()
+		n := 0
+
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				rows.Close()
+				return errors.Wrapf(err, "unable to read row values for table %s", table)
+			}
+
+			ev := snapshotRowToChangeEvent(table, lsn, fields, values, pkColumn)
+
+			jsonRecord, err := marshalChangeEventToJSON(ev)
+			if err != nil {
+				rows.Close()
+				return errors.Wrapf(err, "unable to serialize snapshot row for table %s", table)
+			}
+
+			partitionKey := ev.Key
+			if partitionKey == "" {
+				partitionKey = table
+			}
+
+			if _, err := sink.Put(context.Background(), table, partitionKey, jsonRecord, lsn); err != nil {
+				rows.Close()
+				return errors.Wrapf(err, "unable to put snapshot row into sink for table %s", table)
+			}
+
+			if pkColumn != "" {
+				lastKey = fmt.Sprint(values[pkColumnIndex(fields, pkColumn)])
+			}
+
+			n++
+			rowsCopied++
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return errors.Wrapf(err, "error iterating snapshot rows for table %s", table)
+		}
+
+		if _, err := sink.Flush(context.Background()); err != nil {
+			return errors.Wrapf(err, "unable to flush snapshot rows for table %s", table)
+		}
+
+		if pkColumn == "" || n < DefaultSnapshotChunkSize {
+			break // whole table copied in one shot, or the last (short) page
+		}
+
+		if err := state.save(table, snapshotTableState{LastKey: lastKey}); err != nil {
+			return err
+		}
+	}
+
+	logf("snapshot: copied %d rows from %s", rowsCopied, table)
+
+	return state.save(table, snapshotTableState{Done: true, LastKey: lastKey})
+}
+
+func snapshotSelect(table string, pkColumn string, lastKey string) (string, []interface{}) {
+	quotedTable := quoteQualifiedIdent(table)
+
+	if pkColumn == "" {
+		return fmt.Sprintf("SELECT * FROM %s", quotedTable), nil
+	}
+
+	quotedPK := quoteIdent(pkColumn)
+
+	if lastKey == "" {
+		return fmt.Sprintf("SELECT * FROM %s ORDER BY %s LIMIT %d", quotedTable, quotedPK, DefaultSnapshotChunkSize), nil
+	}
+
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s > $1 ORDER BY %s LIMIT %d", quotedTable, quotedPK, quotedPK, DefaultSnapshotChunkSize), []interface{}{lastKey}
+}
+
+// quoteIdent quotes a single SQL identifier, doubling any embedded double
+// quotes per Postgres's quoting rules.
+func quoteIdent(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+// quoteQualifiedIdent quotes a "schema.table" identifier as
+// "schema"."table" so mixed-case names, reserved words, and special
+// characters survive interpolation into SQL text.
+func quoteQualifiedIdent(name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	for i, p := range parts {
+		parts[i] = quoteIdent(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// snapshotRowToChangeEvent builds a synthetic INSERT event for one snapshot
+// row. Key is set from pkColumn's value, the same way replicaIdentityKey
+// partitions the streaming path, so a bulk copy spreads across sink
+// partitions instead of serializing onto the one named by table.
+func snapshotRowToChangeEvent(table string, lsn uint64, fields []pgx.FieldDescription, values []interface{}, pkColumn string) *ChangeEvent {
+	columns := make(map[string]ColumnChange, len(fields))
+	var key string
+
+	for i, f := range fields {
+		columns[f.Name] = ColumnChange{New: &ColumnValue{Value: values[i], Type: f.DataTypeName, TypeOID: f.DataType}}
+
+		if pkColumn != "" && f.Name == pkColumn {
+			key = fmt.Sprint(values[i])
+		}
+	}
+
+	return &ChangeEvent{
+		LSN:       lsn,
+		Table:     table,
+		Operation: "INSERT",
+		Columns:   columns,
+		Key:       key,
+	}
+}
+
+func pkColumnIndex(fields []pgx.FieldDescription, name string) int {
+	for i, f := range fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// primaryKeyColumn returns the table's primary key column name if (and
+// only if) it has exactly one; composite keys fall back to an unpaginated
+// whole-table copy, since keyset pagination over several columns isn't
+// worth the complexity here.
+func primaryKeyColumn(conn *pgx.Conn, table string) (string, error) {
+	// regclass's input parser honors quoting the same way the SQL parser
+	// does, so pass a quoted identifier rather than the bare "schema.table"
+	// text to avoid case-folding it to the wrong (or a nonexistent) relation.
+	relation := quoteQualifiedIdent(table)
+
+	row := conn.QueryRow(`
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+	`, relation)
+
+	var column string
+	if err := row.Scan(&column); err != nil {
+		if err == pgx.ErrNoRows {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "unable to determine primary key for table %s", table)
+	}
+
+	// a second row would mean a composite key; QueryRow only gives us the
+	// first, so check explicitly via a count instead of guessing.
+	var count int
+	if err := conn.QueryRow(`
+		SELECT count(*)
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+	`, relation).Scan(&count); err != nil {
+		return "", errors.Wrapf(err, "unable to determine primary key column count for table %s", table)
+	}
+
+	if count != 1 {
+		return "", nil
+	}
+
+	return column, nil
+}
+
+// listSnapshotTables enumerates user tables and applies -t/-T so the
+// snapshot covers exactly the tables the replication stream would.
+func listSnapshotTables(conn *pgx.Conn) ([]string, error) {
+	rows, err := conn.Query(`
+		SELECT schemaname, tablename
+		FROM pg_tables
+		WHERE schemaname NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY schemaname, tablename
+	`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list tables for initial snapshot")
+	}
+	defer rows.Close()
+
+	var tableNames []string
+
+	for rows.Next() {
+		var schema, name string
+		if err := rows.Scan(&schema, &name); err != nil {
+			return nil, errors.Wrap(err, "unable to scan table name for initial snapshot")
+		}
+
+		qualified := schema + "." + name
+		if matchesTableFilters(qualified) {
+			tableNames = append(tableNames, qualified)
+		}
+	}
+
+	return tableNames, rows.Err()
+}